@@ -0,0 +1,308 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/newrelic/infra-integrations-sdk/data/metric"
+	"github.com/newrelic/infra-integrations-sdk/integration"
+	"github.com/newrelic/infra-integrations-sdk/log"
+	"github.com/newrelic/nri-jmx/src/exporter"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// rawCollectionDefinition is the unvalidated shape of a collection file,
+// the same `collect:` document config_convert.go's output also produces,
+// plus an optional `extends:` to merge in another file.
+type rawCollectionDefinition struct {
+	Extends string          `yaml:"extends"`
+	Collect []*domainOutput `yaml:"collect"`
+}
+
+// collectionDefinition is a validated, ready-to-run collection: one entity
+// per domain/event type, one query per bean.
+type collectionDefinition struct {
+	Domains []*domainOutput
+}
+
+var validMetricTypes = map[string]bool{
+	"gauge":     true,
+	"delta":     true,
+	"attribute": true,
+}
+
+// envVarPattern matches ${VAR} and ${VAR:-default} references.
+var envVarPattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// parseVars parses a --vars key=val,key2=val2 spec into a lookup map that
+// takes priority over the process environment during interpolation.
+func parseVars(spec string) map[string]string {
+	vars := make(map[string]string)
+	if spec == "" {
+		return vars
+	}
+
+	for _, pair := range strings.Split(spec, ",") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		vars[kv[0]] = kv[1]
+	}
+
+	return vars
+}
+
+// interpolate replaces every ${VAR} / ${VAR:-default} reference in raw
+// with its value from vars, falling back to the process environment and
+// then the default.
+func interpolate(raw []byte, vars map[string]string) []byte {
+	return envVarPattern.ReplaceAllFunc(raw, func(match []byte) []byte {
+		groups := envVarPattern.FindSubmatch(match)
+		name := string(groups[1])
+		def := string(groups[3])
+
+		if v, ok := vars[name]; ok {
+			return []byte(v)
+		}
+		if v, ok := os.LookupEnv(name); ok {
+			return []byte(v)
+		}
+		return []byte(def)
+	})
+}
+
+// parseYaml reads and unmarshals a collection definition file, interpolating
+// ${VAR} references and recursively merging in any file it `extends`.
+func parseYaml(filename string) (*rawCollectionDefinition, error) {
+	return parseYamlWithVars(filename, parseVars(args.Vars))
+}
+
+func parseYamlWithVars(filename string, vars map[string]string) (*rawCollectionDefinition, error) {
+	return parseYamlWithVisited(filename, vars, nil)
+}
+
+// parseYamlWithVisited is parseYamlWithVars with the chain of files already
+// being loaded (keyed by absolute path) threaded through, so an `extends`
+// cycle (a.yml extends b.yml extends a.yml) is reported as an error instead
+// of recursing until the stack or memory gives out.
+func parseYamlWithVisited(filename string, vars map[string]string, visited map[string]bool) (*rawCollectionDefinition, error) {
+	absPath, err := filepath.Abs(filename)
+	if err != nil {
+		return nil, err
+	}
+	if visited[absPath] {
+		return nil, fmt.Errorf("extends cycle detected: %s is already being loaded", filename)
+	}
+
+	raw, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	raw = interpolate(raw, vars)
+
+	var def rawCollectionDefinition
+	if err := yaml.Unmarshal(raw, &def); err != nil {
+		return nil, err
+	}
+
+	if def.Extends == "" {
+		return &def, nil
+	}
+
+	basePath := def.Extends
+	if !filepath.IsAbs(basePath) {
+		basePath = filepath.Join(filepath.Dir(filename), basePath)
+	}
+
+	childVisited := make(map[string]bool, len(visited)+1)
+	for k := range visited {
+		childVisited[k] = true
+	}
+	childVisited[absPath] = true
+
+	base, err := parseYamlWithVisited(basePath, vars, childVisited)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load %s (extended by %s): %w", basePath, filename, err)
+	}
+
+	return mergeCollectionDefinitions(base, &def), nil
+}
+
+// mergeCollectionDefinitions deep-merges overlay on top of base: domains are
+// concatenated, but a bean sharing a domain+query is merged attribute by
+// attribute (matched on `attr`), with overlay winning on collisions — last
+// file wins for scalars, same as the compose-go loader.
+func mergeCollectionDefinitions(base, overlay *rawCollectionDefinition) *rawCollectionDefinition {
+	merged := &rawCollectionDefinition{}
+
+	domainIndex := make(map[string]*domainOutput)
+	for _, domain := range base.Collect {
+		merged.Collect = append(merged.Collect, domain)
+		domainIndex[domain.Domain] = domain
+	}
+
+	for _, domain := range overlay.Collect {
+		existing, ok := domainIndex[domain.Domain]
+		if !ok {
+			merged.Collect = append(merged.Collect, domain)
+			domainIndex[domain.Domain] = domain
+			continue
+		}
+
+		if domain.EventType != "" {
+			existing.EventType = domain.EventType
+		}
+		existing.Beans = mergeBeans(existing.Beans, domain.Beans)
+	}
+
+	return merged
+}
+
+func mergeBeans(base, overlay []*beanOutput) []*beanOutput {
+	beanIndex := make(map[string]*beanOutput)
+	merged := make([]*beanOutput, 0, len(base)+len(overlay))
+
+	for _, bean := range base {
+		merged = append(merged, bean)
+		beanIndex[bean.Query] = bean
+	}
+
+	for _, bean := range overlay {
+		existing, ok := beanIndex[bean.Query]
+		if !ok {
+			merged = append(merged, bean)
+			beanIndex[bean.Query] = bean
+			continue
+		}
+		existing.Attributes = mergeAttributes(existing.Attributes, bean.Attributes)
+	}
+
+	return merged
+}
+
+func mergeAttributes(base, overlay []*attributeOutput) []*attributeOutput {
+	attrIndex := make(map[string]int, len(base))
+	merged := make([]*attributeOutput, len(base))
+	copy(merged, base)
+	for i, attr := range merged {
+		attrIndex[attr.Attr] = i
+	}
+
+	for _, attr := range overlay {
+		if i, ok := attrIndex[attr.Attr]; ok {
+			merged[i] = attr
+			continue
+		}
+		attrIndex[attr.Attr] = len(merged)
+		merged = append(merged, attr)
+	}
+
+	return merged
+}
+
+// renderConfig prints the fully interpolated and merged form of every
+// configured collection file and exits, for debugging --vars/extends.
+func renderConfig() error {
+	vars := parseVars(args.Vars)
+
+	for _, collectionFile := range strings.Split(args.CollectionFiles, ",") {
+		def, err := parseYamlWithVars(collectionFile, vars)
+		if err != nil {
+			return fmt.Errorf("failed to parse collection definition file %s: %w", collectionFile, err)
+		}
+
+		out, err := yaml.Marshal(&collectOutput{Collect: def.Collect})
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("# %s\n%s\n", collectionFile, string(out))
+	}
+
+	return nil
+}
+
+// parseCollectionDefinition validates a raw collection definition, making
+// sure every attribute declares a known metric_type.
+func parseCollectionDefinition(raw *rawCollectionDefinition) (*collectionDefinition, error) {
+	for _, domain := range raw.Collect {
+		if domain.Domain == "" {
+			return nil, fmt.Errorf("collection definition is missing a domain name")
+		}
+		for _, bean := range domain.Beans {
+			for _, attr := range bean.Attributes {
+				if !validMetricTypes[attr.MetricType] {
+					return nil, fmt.Errorf("domain %s: attribute %s has unknown metric_type %q", domain.Domain, attr.Attr, attr.MetricType)
+				}
+			}
+		}
+	}
+
+	return &collectionDefinition{Domains: raw.Collect}, nil
+}
+
+// runCollection queries the currently open global JMX connection
+// (jmxQueryFunc) for every bean in the collection and reports the requested
+// attributes as metrics on one entity per domain/event type.
+func runCollection(collection *collectionDefinition, jmxIntegration *integration.Integration) error {
+	return runCollectionWithQuery(collection, jmxIntegration, jmxQueryFunc)
+}
+
+// runCollectionWithQuery is runCollection with the query function injected,
+// so callers that hold their own JMX connection (e.g. targets.go's
+// per-target nrjmx subprocesses) can run a collection against it without
+// going through the single global connection jmxQueryFunc wraps.
+func runCollectionWithQuery(collection *collectionDefinition, jmxIntegration *integration.Integration, queryFunc func(query string, timeoutMs int) (map[string]interface{}, error)) error {
+	for _, domain := range collection.Domains {
+		entity, err := jmxIntegration.Entity(domain.EventType, "jmx-domain")
+		if err != nil {
+			return fmt.Errorf("failed to create entity for domain %s: %w", domain.Domain, err)
+		}
+
+		for _, bean := range domain.Beans {
+			query := domain.Domain + ":" + bean.Query
+
+			results, err := queryFunc(query, args.Timeout)
+			if err != nil {
+				log.Warn("Failed to query %s: %s", query, err)
+				continue
+			}
+
+			metricSet := entity.NewMetricSet(domain.EventType)
+			for _, attr := range bean.Attributes {
+				value, ok := results[query+","+attr.Attr]
+				if !ok {
+					continue
+				}
+				if err := setMetric(metricSet, domain.EventType, attr, value); err != nil {
+					log.Warn("Failed to set metric %s: %s", attr.MetricName, err)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// setMetric sets attr's value on metricSet and records the metric_type it
+// was declared with, scoped to eventType, so exporters that don't see
+// metric.Set's type (see exporter.RecordMetricType) can classify it
+// correctly later.
+func setMetric(metricSet *metric.Set, eventType string, attr *attributeOutput, value interface{}) error {
+	exporter.RecordMetricType(eventType, attr.MetricName, attr.MetricType)
+
+	switch attr.MetricType {
+	case "delta":
+		return metricSet.SetMetric(attr.MetricName, value, metric.DELTA)
+	case "attribute":
+		return metricSet.SetMetric(attr.MetricName, value, metric.ATTRIBUTE)
+	default:
+		return metricSet.SetMetric(attr.MetricName, value, metric.GAUGE)
+	}
+}
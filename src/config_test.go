@@ -0,0 +1,76 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestMergeAttributes(t *testing.T) {
+	base := []*attributeOutput{
+		{Attr: "UsedBytes", MetricType: "gauge", MetricName: "jvm.heap.used"},
+		{Attr: "MaxBytes", MetricType: "gauge", MetricName: "jvm.heap.max"},
+	}
+	overlay := []*attributeOutput{
+		{Attr: "UsedBytes", MetricType: "delta", MetricName: "jvm.heap.used"},
+		{Attr: "CommittedBytes", MetricType: "gauge", MetricName: "jvm.heap.committed"},
+	}
+
+	merged := mergeAttributes(base, overlay)
+
+	if len(merged) != 3 {
+		t.Fatalf("expected 3 merged attributes, got %d", len(merged))
+	}
+
+	byAttr := make(map[string]*attributeOutput, len(merged))
+	for _, attr := range merged {
+		byAttr[attr.Attr] = attr
+	}
+
+	if got := byAttr["UsedBytes"].MetricType; got != "delta" {
+		t.Errorf("expected overlay to win on UsedBytes.MetricType, got %q", got)
+	}
+	if _, ok := byAttr["MaxBytes"]; !ok {
+		t.Error("expected MaxBytes from base to be preserved")
+	}
+	if _, ok := byAttr["CommittedBytes"]; !ok {
+		t.Error("expected CommittedBytes from overlay to be added")
+	}
+}
+
+func TestParseYamlExtendsCycleDetected(t *testing.T) {
+	dir := t.TempDir()
+
+	aPath := filepath.Join(dir, "a.yml")
+	bPath := filepath.Join(dir, "b.yml")
+
+	if err := ioutil.WriteFile(aPath, []byte("extends: b.yml\ncollect: []\n"), 0644); err != nil {
+		t.Fatalf("failed to write a.yml: %s", err)
+	}
+	if err := ioutil.WriteFile(bPath, []byte("extends: a.yml\ncollect: []\n"), 0644); err != nil {
+		t.Fatalf("failed to write b.yml: %s", err)
+	}
+
+	_, err := parseYamlWithVars(aPath, nil)
+	if err == nil {
+		t.Fatal("expected an extends-cycle error, got nil")
+	}
+}
+
+func TestParseYamlExtendsWithoutCycle(t *testing.T) {
+	dir := t.TempDir()
+
+	basePath := filepath.Join(dir, "base.yml")
+	childPath := filepath.Join(dir, "child.yml")
+
+	if err := ioutil.WriteFile(basePath, []byte("collect: []\n"), 0644); err != nil {
+		t.Fatalf("failed to write base.yml: %s", err)
+	}
+	if err := ioutil.WriteFile(childPath, []byte("extends: base.yml\ncollect: []\n"), 0644); err != nil {
+		t.Fatalf("failed to write child.yml: %s", err)
+	}
+
+	if _, err := parseYamlWithVars(childPath, nil); err != nil {
+		t.Fatalf("expected no error for a non-cyclic extends chain, got %s", err)
+	}
+}
@@ -0,0 +1,265 @@
+// Package discover implements local JVM auto-discovery. It enumerates
+// HotSpot JVMs running on the host the same way `jps` does, by reading the
+// per-process counter files the JVM maintains under the OS temp directory,
+// and resolves (or starts) a local JMX connector for each one found.
+package discover
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// JVM describes a single JVM process discovered on the local host.
+type JVM struct {
+	PID       int
+	MainClass string
+	Command   string
+}
+
+// hsperfdataGlob matches the per-user directories HotSpot creates to hold
+// the memory-mapped counter files for every JVM it starts. On Windows the
+// equivalent lives under "%TEMP%\\hsperfdata_<user>", which filepath.Glob
+// also matches since os.TempDir() is used to build the pattern.
+var hsperfdataGlob = filepath.Join(os.TempDir(), "hsperfdata_*")
+
+// Perfdata counter names jps/jcmd rely on.
+const (
+	perfDataCommandProperty   = "sun.rt.javaCommand"
+	perfDataConnectorProperty = "sun.management.JMXConnectorServer.address"
+)
+
+const perfDataMagic = 0xcafec0c0
+
+// perfDataHeader mirrors the PRFDATA prologue HotSpot writes at the start
+// of every hsperfdata file (see hotspot/share/runtime/perfMemory.hpp).
+type perfDataHeader struct {
+	Magic        uint32
+	ByteOrder    uint8
+	MajorVersion uint8
+	MinorVersion uint8
+	Accessible   uint8
+	Used         uint32
+	Overflow     uint32
+	ModTimeStamp int64
+	EntryOffset  uint32
+	NumEntries   uint32
+}
+
+// perfDataEntryHeader mirrors the per-counter header that precedes each
+// entry's name and value.
+type perfDataEntryHeader struct {
+	EntryLength     uint32
+	NameOffset      uint32
+	VectorLength    uint32
+	DataType        uint8
+	Flags           uint8
+	DataUnits       uint8
+	DataVariability uint8
+	DataOffset      uint32
+}
+
+// perfDataByteOrder inspects the raw magic number at the start of an
+// hsperfdata file to determine the byte order the writing JVM used, without
+// assuming one ahead of time. HotSpot always writes the header in its own
+// native order, so on a little-endian host (virtually every real x86-64/arm64
+// deployment) decoding the header as big-endian garbles Magic itself, not
+// just the fields after it.
+func perfDataByteOrder(raw []byte) (binary.ByteOrder, error) {
+	if len(raw) < 4 {
+		return nil, fmt.Errorf("file too short to contain a perfdata header")
+	}
+
+	switch uint32(perfDataMagic) {
+	case binary.BigEndian.Uint32(raw[0:4]):
+		return binary.BigEndian, nil
+	case binary.LittleEndian.Uint32(raw[0:4]):
+		return binary.LittleEndian, nil
+	default:
+		return nil, fmt.Errorf("not a hotspot perfdata file")
+	}
+}
+
+// ScanLocalJVMs enumerates every JVM whose hsperfdata counters are
+// readable by the current user.
+func ScanLocalJVMs() ([]JVM, error) {
+	dirs, err := filepath.Glob(hsperfdataGlob)
+	if err != nil {
+		return nil, err
+	}
+
+	var jvms []JVM
+	for _, dir := range dirs {
+		entries, err := ioutil.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+
+		for _, entry := range entries {
+			pid, err := strconv.Atoi(entry.Name())
+			if err != nil {
+				continue
+			}
+
+			props, err := readPerfData(filepath.Join(dir, entry.Name()))
+			if err != nil {
+				continue
+			}
+
+			command := props[perfDataCommandProperty]
+			jvms = append(jvms, JVM{
+				PID:       pid,
+				MainClass: mainClassFromCommand(command),
+				Command:   command,
+			})
+		}
+	}
+
+	return jvms, nil
+}
+
+// ResolveConnectorURL returns a service:jmx: URL for pid, starting the
+// management agent first if the JVM isn't already exposing a local
+// connector.
+func ResolveConnectorURL(pid int) (string, error) {
+	if addr, err := LocalConnectorAddress(pid); err == nil {
+		return addr, nil
+	}
+
+	if err := StartManagementAgent(pid); err != nil {
+		return "", err
+	}
+
+	return LocalConnectorAddress(pid)
+}
+
+// LocalConnectorAddress returns the connector address already published
+// by pid's management agent, via the same perfdata counter
+// sun.management.ConnectorAddressLink reads.
+func LocalConnectorAddress(pid int) (string, error) {
+	dir, err := hsperfdataDirForPID(pid)
+	if err != nil {
+		return "", err
+	}
+
+	props, err := readPerfData(filepath.Join(dir, strconv.Itoa(pid)))
+	if err != nil {
+		return "", err
+	}
+
+	addr, ok := props[perfDataConnectorProperty]
+	if !ok || addr == "" {
+		return "", fmt.Errorf("pid %d: no local JMX connector published", pid)
+	}
+
+	return addr, nil
+}
+
+// StartManagementAgent starts the management agent inside the target JVM,
+// equivalent to running `jcmd <pid> ManagementAgent.start_local` by hand.
+func StartManagementAgent(pid int) error {
+	out, err := exec.Command("jcmd", strconv.Itoa(pid), "ManagementAgent.start_local").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("jcmd ManagementAgent.start_local failed for pid %d: %s: %w", pid, strings.TrimSpace(string(out)), err)
+	}
+	return nil
+}
+
+func hsperfdataDirForPID(pid int) (string, error) {
+	dirs, err := filepath.Glob(hsperfdataGlob)
+	if err != nil {
+		return "", err
+	}
+
+	for _, dir := range dirs {
+		if _, err := os.Stat(filepath.Join(dir, strconv.Itoa(pid))); err == nil {
+			return dir, nil
+		}
+	}
+
+	return "", fmt.Errorf("pid %d: no hsperfdata entry found", pid)
+}
+
+// readPerfData parses just enough of a hsperfdata file's binary format to
+// pull out the string-valued counters (sun.rt.javaCommand and friends);
+// numeric counters are skipped since nothing here needs them.
+func readPerfData(path string) (map[string]string, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	order, err := perfDataByteOrder(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	var header perfDataHeader
+	if err := binary.Read(bytes.NewReader(raw), order, &header); err != nil {
+		return nil, err
+	}
+
+	props := make(map[string]string, header.NumEntries)
+	offset := header.EntryOffset
+
+	for i := uint32(0); i < header.NumEntries && int(offset) < len(raw); i++ {
+		var entry perfDataEntryHeader
+		if err := binary.Read(bytes.NewReader(raw[offset:]), order, &entry); err != nil {
+			break
+		}
+		if entry.EntryLength == 0 {
+			break
+		}
+
+		if name, ok := nullTerminated(raw, offset+entry.NameOffset); ok {
+			// Only string-typed counters ('B' == byte array, used to
+			// store CHAR/String vectors) are relevant here.
+			if entry.DataType == 'B' || entry.DataType == 'C' {
+				dataStart := offset + entry.DataOffset
+				dataEnd := dataStart + entry.VectorLength
+				if dataEnd <= uint32(len(raw)) {
+					value := raw[dataStart:dataEnd]
+					if n := bytes.IndexByte(value, 0); n >= 0 {
+						value = value[:n]
+					}
+					props[name] = string(value)
+				}
+			}
+		}
+
+		offset += entry.EntryLength
+	}
+
+	return props, nil
+}
+
+func nullTerminated(raw []byte, start uint32) (string, bool) {
+	if int(start) >= len(raw) {
+		return "", false
+	}
+	end := start
+	for int(end) < len(raw) && raw[end] != 0 {
+		end++
+	}
+	return string(raw[start:end]), true
+}
+
+// mainClassFromCommand extracts the main class (or jar) from the raw
+// command line recorded in sun.rt.javaCommand, e.g. "com.foo.Main --port 9"
+// or "foo.jar --port 9".
+func mainClassFromCommand(command string) string {
+	fields := strings.Fields(command)
+	if len(fields) == 0 {
+		return ""
+	}
+	if fields[0] == "-jar" && len(fields) > 1 {
+		return fields[1]
+	}
+	return fields[0]
+}
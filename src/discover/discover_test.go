@@ -0,0 +1,108 @@
+package discover
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// buildPerfData encodes a minimal hsperfdata file containing a single
+// string-valued counter, using order, for tests to round-trip against.
+func buildPerfData(t *testing.T, order binary.ByteOrder, counterName, counterValue string) []byte {
+	t.Helper()
+
+	nameBytes := append([]byte(counterName), 0)
+	valueBytes := append([]byte(counterValue), 0)
+
+	const headerSize = 4 + 1 + 1 + 1 + 1 + 4 + 4 + 8 + 4 + 4
+	const entryHeaderSize = 4 + 4 + 4 + 1 + 1 + 1 + 1 + 4
+
+	nameOffset := uint32(entryHeaderSize)
+	dataOffset := nameOffset + uint32(len(nameBytes))
+	entryLength := dataOffset + uint32(len(valueBytes))
+
+	var byteOrderFlag uint8
+	if order == binary.LittleEndian {
+		byteOrderFlag = 1
+	}
+
+	buf := &bytes.Buffer{}
+	header := perfDataHeader{
+		Magic:        perfDataMagic,
+		ByteOrder:    byteOrderFlag,
+		MajorVersion: 2,
+		MinorVersion: 0,
+		Accessible:   1,
+		Used:         0,
+		Overflow:     0,
+		ModTimeStamp: 0,
+		EntryOffset:  uint32(headerSize),
+		NumEntries:   1,
+	}
+	if err := binary.Write(buf, order, &header); err != nil {
+		t.Fatalf("failed to write test header: %s", err)
+	}
+
+	entry := perfDataEntryHeader{
+		EntryLength:     entryLength,
+		NameOffset:      nameOffset,
+		VectorLength:    uint32(len(valueBytes)),
+		DataType:        'B',
+		Flags:           0,
+		DataUnits:       0,
+		DataVariability: 0,
+		DataOffset:      dataOffset,
+	}
+	if err := binary.Write(buf, order, &entry); err != nil {
+		t.Fatalf("failed to write test entry: %s", err)
+	}
+	buf.Write(nameBytes)
+	buf.Write(valueBytes)
+
+	return buf.Bytes()
+}
+
+func TestReadPerfData(t *testing.T) {
+	tests := []struct {
+		name  string
+		order binary.ByteOrder
+	}{
+		{name: "big endian", order: binary.BigEndian},
+		{name: "little endian", order: binary.LittleEndian},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			raw := buildPerfData(t, tc.order, perfDataCommandProperty, "com.foo.Main --port 9")
+
+			dir := t.TempDir()
+			path := filepath.Join(dir, "12345")
+			if err := ioutil.WriteFile(path, raw, 0644); err != nil {
+				t.Fatalf("failed to write test perfdata file: %s", err)
+			}
+
+			props, err := readPerfData(path)
+			if err != nil {
+				t.Fatalf("readPerfData returned error: %s", err)
+			}
+
+			if got := props[perfDataCommandProperty]; got != "com.foo.Main --port 9" {
+				t.Errorf("expected %s = %q, got %q", perfDataCommandProperty, "com.foo.Main --port 9", got)
+			}
+		})
+	}
+}
+
+func TestReadPerfDataRejectsBadMagic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "12345")
+	if err := ioutil.WriteFile(path, []byte("not a perfdata file"), 0644); err != nil {
+		t.Fatalf("failed to write test file: %s", err)
+	}
+
+	if _, err := readPerfData(path); err == nil {
+		t.Fatal("expected an error for a file with no valid perfdata magic, got nil")
+	}
+}
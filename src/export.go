@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/newrelic/infra-integrations-sdk/integration"
+	"github.com/newrelic/infra-integrations-sdk/log"
+	"github.com/newrelic/nri-jmx/src/exporter"
+)
+
+// runPass performs a single collection pass into jmxIntegration, using
+// whichever mode the args select.
+func runPass(jmxIntegration *integration.Integration) error {
+	if args.DiscoverLocal == "true" {
+		return runLocalDiscovery(jmxIntegration)
+	}
+	if args.TargetsFile != "" {
+		return runTargetsCollection(jmxIntegration)
+	}
+	return runSingleHostCollection(jmxIntegration)
+}
+
+// runSingleHostCollection is the original nri-jmx flow: open one JMX
+// connection to JmxHost/JmxPort and run every collection file against it.
+func runSingleHostCollection(jmxIntegration *integration.Integration) error {
+	if err := jmxOpenFunc(args.JmxHost, args.JmxPort, args.JmxUser, args.JmxPass); err != nil {
+		return fmt.Errorf(
+			"failed to open JMX connection (host: %s, port: %s, user: %s, pass: %s): %w",
+			args.JmxHost, args.JmxPort, args.JmxUser, args.JmxPass, err,
+		)
+	}
+	defer jmxCloseFunc()
+
+	for _, collectionFile := range strings.Split(args.CollectionFiles, ",") {
+		if !filepath.IsAbs(collectionFile) {
+			return fmt.Errorf("invalid metrics collection path %s: must be an absolute path", collectionFile)
+		}
+
+		collectionDefinition, err := parseYaml(collectionFile)
+		if err != nil {
+			return fmt.Errorf("failed to parse collection definition file %s: %w", collectionFile, err)
+		}
+
+		collection, err := parseCollectionDefinition(collectionDefinition)
+		if err != nil {
+			return fmt.Errorf("failed to parse collection definition %s: %w", collectionFile, err)
+		}
+
+		if err := runCollection(collection, jmxIntegration); err != nil {
+			log.Error("Failed to complete collection: %s", err)
+		}
+	}
+
+	return nil
+}
+
+// buildExporters resolves the comma-separated --exporter flag into the
+// Exporter implementations that should receive this run's entities.
+func buildExporters(jmxIntegration *integration.Integration) ([]exporter.Exporter, error) {
+	var exporters []exporter.Exporter
+
+	for _, name := range strings.Split(args.Exporter, ",") {
+		switch strings.TrimSpace(name) {
+		case "newrelic":
+			exporters = append(exporters, &exporter.NewRelicExporter{Integration: jmxIntegration})
+		case "prometheus":
+			exporters = append(exporters, exporter.NewPrometheusExporter(args.PromListen))
+		case "otlp":
+			if args.OTLPEndpoint == "" {
+				return nil, fmt.Errorf("--exporter=otlp requires --otlp-endpoint to be set")
+			}
+			exporters = append(exporters, exporter.NewOTLPExporter(args.OTLPEndpoint))
+		default:
+			return nil, fmt.Errorf("unknown exporter %q", name)
+		}
+	}
+
+	return exporters, nil
+}
+
+// hasLongRunningExporter reports whether spec selects an exporter that's
+// meant to stay up and be scraped/polled repeatedly (prometheus, otlp)
+// rather than publish once and exit (newrelic). --interval only makes
+// sense alongside one of these.
+func hasLongRunningExporter(spec string) bool {
+	for _, name := range strings.Split(spec, ",") {
+		switch strings.TrimSpace(name) {
+		case "prometheus", "otlp":
+			return true
+		}
+	}
+	return false
+}
+
+// requiresInterval reports whether spec selects an exporter that only
+// serves metrics for as long as the process keeps running, so running
+// without --interval would be pointless: main would tear down the
+// background HTTP server right after the one collection pass it started,
+// before anything could scrape it.
+func requiresInterval(spec string) bool {
+	for _, name := range strings.Split(spec, ",") {
+		if strings.TrimSpace(name) == "prometheus" {
+			return true
+		}
+	}
+	return false
+}
+
+func exportEntities(exporters []exporter.Exporter, entities []*integration.Entity) {
+	for _, exp := range exporters {
+		if err := exp.Export(entities); err != nil {
+			log.Error("Exporter failed: %s", err)
+		}
+	}
+}
+
+// runCollectionLoop re-runs a full collection pass every --interval
+// seconds, exporting the result each time. Callers only start this when an
+// exporter (like PrometheusExporter) doesn't exit after a single pass.
+func runCollectionLoop(exporters []exporter.Exporter) {
+	ticker := time.NewTicker(time.Duration(args.Interval) * time.Second)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		// Built without integration.Args(&args), same reason as
+		// collectTarget's localIntegration in targets.go: args were
+		// already parsed once by the top-level jmxIntegration in jmx.go's
+		// main, and the SDK panics if the same flags are registered
+		// again. passIntegration only needs a place to hold this pass's
+		// entities before they're exported below.
+		passIntegration, err := integration.New(integrationName, integrationVersion)
+		if err != nil {
+			log.Error("Failed to start next collection pass: %s", err)
+			continue
+		}
+
+		if err := runPass(passIntegration); err != nil {
+			log.Error("Collection pass failed: %s", err)
+			continue
+		}
+
+		passIntegration.Entities = checkMetricLimit(passIntegration.Entities)
+		exportEntities(exporters, passIntegration.Entities)
+	}
+}
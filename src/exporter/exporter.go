@@ -0,0 +1,25 @@
+// Package exporter decouples collection from publishing. nri-jmx's
+// built-in Publish() call is one Exporter among several, so the
+// integration can be run outside of the New Relic agent too.
+package exporter
+
+import (
+	"github.com/newrelic/infra-integrations-sdk/integration"
+)
+
+// Exporter publishes a collection run's entities to some backend.
+type Exporter interface {
+	Export(entities []*integration.Entity) error
+}
+
+// NewRelicExporter publishes entities the same way nri-jmx always has,
+// through the integration's own Publish() call.
+type NewRelicExporter struct {
+	Integration *integration.Integration
+}
+
+// Export hands entities to the integration and publishes them.
+func (e *NewRelicExporter) Export(entities []*integration.Entity) error {
+	e.Integration.Entities = entities
+	return e.Integration.Publish()
+}
@@ -0,0 +1,45 @@
+package exporter
+
+import "sync"
+
+// metricTypes records the metric_type (gauge/delta/attribute) each metric
+// was declared with in its collection YAML, keyed by event type and metric
+// name. Exporters need this because, by the time they see an entity, the
+// SDK's metric.Set has already collapsed each value down to a plain number
+// — the type it was collected with isn't carried along on the entity
+// itself.
+//
+// Scoped by event type rather than bare metric name: generic JMX attribute
+// names like Count/Value/Rate recur across unrelated MBeans, and two
+// collection files declaring the same attribute name with different
+// metric_types would otherwise overwrite each other's classification.
+// Event type is stable across namespacing — namespacedEntityName/
+// namespacedJVMEntityName (see the main package) rename the entity, not its
+// metric sets' event_type — so it's a safe key where a pre-namespacing
+// entity name wouldn't be.
+var (
+	metricTypesMutex sync.Mutex
+	metricTypes      = make(map[string]map[string]string)
+)
+
+// RecordMetricType records the metric_type a collection file declared for
+// metricName under eventType, so exporters can look it up later instead of
+// guessing from the name.
+func RecordMetricType(eventType, metricName, metricType string) {
+	metricTypesMutex.Lock()
+	defer metricTypesMutex.Unlock()
+	if metricTypes[eventType] == nil {
+		metricTypes[eventType] = make(map[string]string)
+	}
+	metricTypes[eventType][metricName] = metricType
+}
+
+// isCounter reports whether metricName was declared with metric_type delta
+// under eventType: gauges for gauge/attribute, counters for delta. Unknown
+// metrics (recorded by
+// something other than config.go's setMetric) default to gauge.
+func isCounter(eventType, metricName string) bool {
+	metricTypesMutex.Lock()
+	defer metricTypesMutex.Unlock()
+	return metricTypes[eventType][metricName] == "delta"
+}
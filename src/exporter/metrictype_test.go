@@ -0,0 +1,30 @@
+package exporter
+
+import "testing"
+
+func TestIsCounter(t *testing.T) {
+	RecordMetricType("JvmSample", "jvm.heap.used", "gauge")
+	RecordMetricType("JvmSample", "jvm.gc.collectionCount", "delta")
+
+	if isCounter("JvmSample", "jvm.heap.used") {
+		t.Error("expected a gauge-typed metric to not be classified as a counter")
+	}
+	if !isCounter("JvmSample", "jvm.gc.collectionCount") {
+		t.Error("expected a delta-typed metric to be classified as a counter")
+	}
+	if isCounter("JvmSample", "never.recorded") {
+		t.Error("expected an unrecorded metric to default to gauge")
+	}
+}
+
+func TestIsCounterScopedByEventType(t *testing.T) {
+	RecordMetricType("FooSample", "Count", "delta")
+	RecordMetricType("BarSample", "Count", "gauge")
+
+	if !isCounter("FooSample", "Count") {
+		t.Error("expected FooSample's Count to stay classified as a counter")
+	}
+	if isCounter("BarSample", "Count") {
+		t.Error("expected BarSample's Count, recorded after FooSample's, to not bleed FooSample's classification")
+	}
+}
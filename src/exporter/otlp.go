@@ -0,0 +1,139 @@
+package exporter
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/newrelic/infra-integrations-sdk/integration"
+)
+
+// OTLPExporter batches collected metrics into an OTLP/HTTP metrics export
+// request and posts it to Endpoint.
+type OTLPExporter struct {
+	Endpoint string
+	Client   *http.Client
+}
+
+// NewOTLPExporter builds an exporter that posts to endpoint.
+func NewOTLPExporter(endpoint string) *OTLPExporter {
+	return &OTLPExporter{
+		Endpoint: endpoint,
+		Client:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Export posts every entity's metrics to Endpoint as a single OTLP/HTTP
+// ExportMetricsServiceRequest, JSON-encoded.
+func (e *OTLPExporter) Export(entities []*integration.Entity) error {
+	body, err := json.Marshal(buildOTLPRequest(entities))
+	if err != nil {
+		return err
+	}
+
+	resp, err := e.Client.Post(e.Endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to post metrics to %s: %w", e.Endpoint, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("OTLP endpoint %s returned status %d", e.Endpoint, resp.StatusCode)
+	}
+
+	return nil
+}
+
+// The following types are a minimal subset of the OTLP metrics JSON schema
+// (opentelemetry-proto/opentelemetry/proto/metrics/v1) — just enough to
+// carry a gauge or monotonic sum per metric.
+
+type otlpRequest struct {
+	ResourceMetrics []otlpResourceMetrics `json:"resourceMetrics"`
+}
+
+type otlpResourceMetrics struct {
+	Resource     otlpResource       `json:"resource"`
+	ScopeMetrics []otlpScopeMetrics `json:"scopeMetrics"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpAttribute struct {
+	Key   string        `json:"key"`
+	Value otlpAttrValue `json:"value"`
+}
+
+type otlpAttrValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpScopeMetrics struct {
+	Metrics []otlpMetric `json:"metrics"`
+}
+
+type otlpMetric struct {
+	Name  string     `json:"name"`
+	Gauge *otlpGauge `json:"gauge,omitempty"`
+	Sum   *otlpSum   `json:"sum,omitempty"`
+}
+
+type otlpGauge struct {
+	DataPoints []otlpNumberDataPoint `json:"dataPoints"`
+}
+
+type otlpSum struct {
+	DataPoints             []otlpNumberDataPoint `json:"dataPoints"`
+	AggregationTemporality int                   `json:"aggregationTemporality"`
+	IsMonotonic            bool                  `json:"isMonotonic"`
+}
+
+type otlpNumberDataPoint struct {
+	TimeUnixNano string  `json:"timeUnixNano"`
+	AsDouble     float64 `json:"asDouble"`
+}
+
+func buildOTLPRequest(entities []*integration.Entity) otlpRequest {
+	now := fmt.Sprintf("%d", time.Now().UnixNano())
+
+	var resourceMetrics []otlpResourceMetrics
+	for _, entity := range entities {
+		var metrics []otlpMetric
+
+		for _, metricSet := range entity.Metrics {
+			eventType, _ := metricSet.Metrics["event_type"].(string)
+			for name, value := range metricSet.Metrics {
+				if name == "event_type" {
+					continue
+				}
+
+				f, ok := toFloat64(value)
+				if !ok {
+					continue
+				}
+
+				dp := otlpNumberDataPoint{TimeUnixNano: now, AsDouble: f}
+				m := otlpMetric{Name: name}
+				if isCounter(eventType, name) {
+					m.Sum = &otlpSum{DataPoints: []otlpNumberDataPoint{dp}, AggregationTemporality: 2, IsMonotonic: true}
+				} else {
+					m.Gauge = &otlpGauge{DataPoints: []otlpNumberDataPoint{dp}}
+				}
+				metrics = append(metrics, m)
+			}
+		}
+
+		resourceMetrics = append(resourceMetrics, otlpResourceMetrics{
+			Resource: otlpResource{
+				Attributes: []otlpAttribute{{Key: "service.name", Value: otlpAttrValue{StringValue: entity.Metadata.Name}}},
+			},
+			ScopeMetrics: []otlpScopeMetrics{{Metrics: metrics}},
+		})
+	}
+
+	return otlpRequest{ResourceMetrics: resourceMetrics}
+}
@@ -0,0 +1,48 @@
+package exporter
+
+import (
+	"testing"
+
+	"github.com/newrelic/infra-integrations-sdk/integration"
+)
+
+func TestBuildOTLPRequestClassifiesGaugeAndCounter(t *testing.T) {
+	RecordMetricType("JVMSample", "jvm.heap.used", "gauge")
+	RecordMetricType("JVMSample", "jvm.gc.collectionCount", "delta")
+
+	entities := []*integration.Entity{
+		entityWithMetric(t, "host-a:9999/JVMSample", "JVMSample", "jvm.heap.used", 100),
+	}
+	// Set directly rather than via SetMetric(..., metric.DELTA): the SDK
+	// requires a delta metric's set to be namespaced by an attribute,
+	// which is irrelevant to what's under test here — buildOTLPRequest
+	// only cares about what ends up in metricSet.Metrics.
+	entities[0].Metrics[0].Metrics["jvm.gc.collectionCount"] = 5.0
+
+	req := buildOTLPRequest(entities)
+
+	if len(req.ResourceMetrics) != 1 {
+		t.Fatalf("expected 1 resourceMetrics entry, got %d", len(req.ResourceMetrics))
+	}
+
+	byName := make(map[string]otlpMetric)
+	for _, m := range req.ResourceMetrics[0].ScopeMetrics[0].Metrics {
+		byName[m.Name] = m
+	}
+
+	gauge, ok := byName["jvm.heap.used"]
+	if !ok {
+		t.Fatal("expected jvm.heap.used in the request")
+	}
+	if gauge.Gauge == nil || gauge.Sum != nil {
+		t.Errorf("expected jvm.heap.used to be encoded as a gauge, got %+v", gauge)
+	}
+
+	counter, ok := byName["jvm.gc.collectionCount"]
+	if !ok {
+		t.Fatal("expected jvm.gc.collectionCount in the request")
+	}
+	if counter.Sum == nil || counter.Gauge != nil || !counter.Sum.IsMonotonic {
+		t.Errorf("expected jvm.gc.collectionCount to be encoded as a monotonic sum, got %+v", counter)
+	}
+}
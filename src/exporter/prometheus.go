@@ -0,0 +1,124 @@
+package exporter
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/newrelic/infra-integrations-sdk/integration"
+	"github.com/newrelic/infra-integrations-sdk/log"
+)
+
+// PrometheusExporter serves the most recently collected metrics in
+// Prometheus text exposition format on ListenAddress. It does not exit
+// after one collection pass: Export atomically swaps the snapshot served
+// at /metrics, which main() calls once per --interval.
+type PrometheusExporter struct {
+	ListenAddress string
+
+	snapshot atomic.Value // []*integration.Entity
+}
+
+// NewPrometheusExporter starts an HTTP server on listenAddress in the
+// background and returns immediately.
+func NewPrometheusExporter(listenAddress string) *PrometheusExporter {
+	e := &PrometheusExporter{ListenAddress: listenAddress}
+	e.snapshot.Store([]*integration.Entity{})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", e.handleMetrics)
+
+	go func() {
+		if err := http.ListenAndServe(listenAddress, mux); err != nil {
+			log.Error("Prometheus exporter HTTP server on %s failed: %s", listenAddress, err)
+		}
+	}()
+
+	return e
+}
+
+// Export swaps the snapshot served at /metrics.
+func (e *PrometheusExporter) Export(entities []*integration.Entity) error {
+	e.snapshot.Store(entities)
+	return nil
+}
+
+// promSample is one entity's value for a metric family.
+type promSample struct {
+	entityName string
+	value      interface{}
+}
+
+// promFamily groups every entity's sample for one sanitized metric name, so
+// handleMetrics can emit a single "# TYPE" comment for the family instead of
+// one per entity.
+type promFamily struct {
+	kind    string
+	samples []promSample
+}
+
+func (e *PrometheusExporter) handleMetrics(w http.ResponseWriter, _ *http.Request) {
+	entities := e.snapshot.Load().([]*integration.Entity)
+
+	var order []string
+	families := make(map[string]*promFamily)
+	for _, entity := range entities {
+		for _, metricSet := range entity.Metrics {
+			eventType, _ := metricSet.Metrics["event_type"].(string)
+			for name, value := range metricSet.Metrics {
+				if name == "event_type" {
+					continue
+				}
+				if _, ok := toFloat64(value); !ok {
+					continue
+				}
+
+				metricName := sanitizeMetricName(name)
+				f, ok := families[metricName]
+				if !ok {
+					kind := "gauge"
+					if isCounter(eventType, name) {
+						kind = "counter"
+					}
+					f = &promFamily{kind: kind}
+					families[metricName] = f
+					order = append(order, metricName)
+				}
+				f.samples = append(f.samples, promSample{entityName: entity.Metadata.Name, value: value})
+			}
+		}
+	}
+
+	var b strings.Builder
+	for _, metricName := range order {
+		f := families[metricName]
+		fmt.Fprintf(&b, "# TYPE %s %s\n", metricName, f.kind)
+		for _, s := range f.samples {
+			fmt.Fprintf(&b, "%s{entity=%q} %v\n", metricName, s.entityName, s.value)
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprint(w, b.String())
+}
+
+func sanitizeMetricName(name string) string {
+	replacer := strings.NewReplacer(".", "_", "-", "_", " ", "_")
+	return replacer.Replace(name)
+}
+
+func toFloat64(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	default:
+		return 0, false
+	}
+}
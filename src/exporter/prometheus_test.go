@@ -0,0 +1,72 @@
+package exporter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/newrelic/infra-integrations-sdk/data/metric"
+	"github.com/newrelic/infra-integrations-sdk/integration"
+	"github.com/newrelic/infra-integrations-sdk/persist"
+)
+
+func entityWithMetric(t *testing.T, entityName, eventType, metricName string, value interface{}) *integration.Entity {
+	t.Helper()
+
+	storer := persist.NewInMemoryStore()
+	metricSet := metric.NewSet(eventType, storer)
+	if err := metricSet.SetMetric(metricName, value, metric.GAUGE); err != nil {
+		t.Fatalf("failed to set metric: %s", err)
+	}
+
+	return &integration.Entity{
+		Metadata: &integration.EntityMetadata{Name: entityName},
+		Metrics:  []*metric.Set{metricSet},
+	}
+}
+
+// TestHandleMetricsEmitsOneTypeLinePerFamily is a regression test: with more
+// than one entity reporting the same metric name (the normal outcome of
+// --discover-local/--targets-file), handleMetrics used to emit a "# TYPE"
+// comment once per entity instead of once per family, which Prometheus's
+// text-format parser rejects as a duplicate.
+func TestHandleMetricsEmitsOneTypeLinePerFamily(t *testing.T) {
+	RecordMetricType("JVMSample", "jvm.heap.used", "gauge")
+
+	e := &PrometheusExporter{}
+	e.snapshot.Store([]*integration.Entity{
+		entityWithMetric(t, "host-a:9999/JVMSample", "JVMSample", "jvm.heap.used", 100),
+		entityWithMetric(t, "host-b:9999/JVMSample", "JVMSample", "jvm.heap.used", 200),
+	})
+
+	rr := httptest.NewRecorder()
+	e.handleMetrics(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	body := rr.Body.String()
+	if got := strings.Count(body, "# TYPE jvm_heap_used gauge"); got != 1 {
+		t.Errorf("expected exactly one TYPE line for jvm_heap_used, got %d in:\n%s", got, body)
+	}
+	if !strings.Contains(body, `jvm_heap_used{entity="host-a:9999/JVMSample"} 100`) {
+		t.Errorf("missing sample for host-a in:\n%s", body)
+	}
+	if !strings.Contains(body, `jvm_heap_used{entity="host-b:9999/JVMSample"} 200`) {
+		t.Errorf("missing sample for host-b in:\n%s", body)
+	}
+}
+
+func TestHandleMetricsClassifiesCounterFromRecordedMetricType(t *testing.T) {
+	RecordMetricType("JVMSample", "jvm.gc.collectionCount", "delta")
+
+	e := &PrometheusExporter{}
+	e.snapshot.Store([]*integration.Entity{
+		entityWithMetric(t, "host-a:9999/JVMSample", "JVMSample", "jvm.gc.collectionCount", 5),
+	})
+
+	rr := httptest.NewRecorder()
+	e.handleMetrics(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if !strings.Contains(rr.Body.String(), "# TYPE jvm_gc_collectionCount counter") {
+		t.Errorf("expected jvm_gc_collectionCount to be classified as a counter, got:\n%s", rr.Body.String())
+	}
+}
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/newrelic/infra-integrations-sdk/log"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// mBeanAttribute is the subset of javax.management.MBeanAttributeInfo this
+// package needs. CompositeKeys is only populated when Type is a
+// CompositeData type, one level deep.
+type mBeanAttribute struct {
+	Name          string
+	Type          string
+	CompositeKeys []string
+}
+
+// numericJavaTypes are the attribute types worth collecting as metrics;
+// everything else is emitted as an `attribute`.
+var numericJavaTypes = map[string]bool{
+	"int": true, "long": true, "float": true, "double": true,
+	"java.lang.Integer": true, "java.lang.Long": true,
+	"java.lang.Float": true, "java.lang.Double": true,
+	"java.util.concurrent.atomic.AtomicInteger": true,
+	"java.util.concurrent.atomic.AtomicLong":    true,
+	"java.util.concurrent.atomic.LongAdder":     true,
+}
+
+var counterNamePattern = regexp.MustCompile(`(?i)count|total|bytes|requests`)
+
+// jmxtermBeanPattern matches a bare object name line from jmxterm's "beans"
+// output, e.g. "java.lang:type=Memory". jmxterm prefixes informational
+// lines with '#', so those are skipped.
+var jmxtermBeanPattern = regexp.MustCompile(`^[A-Za-z0-9_.$]+:\S*=\S*`)
+
+// jmxtermAttrPattern matches one attribute line from jmxterm's "info -b"
+// output, e.g. "  %0   - HeapMemoryUsage (javax.management.openmbean.CompositeData, r)".
+var jmxtermAttrPattern = regexp.MustCompile(`^\s*%\d+\s+-\s+(\S+)\s+\(([^,)]+)`)
+
+// jmxtermCompositeKeyPattern matches one key/value line inside the braces
+// of a "get -b" composite attribute result, e.g. "    used = 123456789;".
+var jmxtermCompositeKeyPattern = regexp.MustCompile(`^\s*(\w+)\s*=`)
+
+// jmxtermCommandFunc runs a jmxterm command script against the configured
+// target and returns whatever it wrote to stdout. It's a variable, the same
+// convention jmx.go uses for jmxOpenFunc/jmxQueryFunc, so introspection can
+// be exercised without a real jmxterm binary on PATH.
+var jmxtermCommandFunc = runJmxtermScript
+
+// runJmxtermScript shells out to the jmxterm CLI (https://github.com/jiaqi/jmxterm),
+// feeding it script on stdin and returning its stdout. jmxterm is used here
+// because the vendored nrjmx client only exposes Open/Close/Query, which
+// return already-flattened attribute values — not the live MBeanInfo
+// metadata (attribute types, composite keys) introspection needs.
+func runJmxtermScript(script string) (string, error) {
+	cmd := exec.Command("jmxterm")
+	cmd.Stdin = strings.NewReader(script)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("%s: %w", strings.TrimSpace(stderr.String()), err)
+	}
+
+	return stdout.String(), nil
+}
+
+// jmxtermOpenCommand builds the jmxterm "open" line for the currently
+// configured JMX target, matching the same host/port/user/pass nri-jmx
+// connects to everywhere else.
+func jmxtermOpenCommand() string {
+	if args.JmxUser == "" && args.JmxPass == "" {
+		return fmt.Sprintf("open %s:%s\n", args.JmxHost, args.JmxPort)
+	}
+	return fmt.Sprintf("open %s:%s -u %s -p %s\n", args.JmxHost, args.JmxPort, args.JmxUser, args.JmxPass)
+}
+
+// runIntrospect connects to the configured JMX endpoint via jmxterm, walks
+// every domain and bean it exposes, and emits a ready-to-edit nri-jmx
+// collection file built from what it finds, so a user doesn't have to write
+// collection YAML blind against vendor docs.
+func runIntrospect() error {
+	objectNames, err := listMBeanNames()
+	if err != nil {
+		return fmt.Errorf("failed to list mbeans: %w", err)
+	}
+
+	attrsByBean, err := describeMBeans(objectNames)
+	if err != nil {
+		return fmt.Errorf("failed to query mbean info: %w", err)
+	}
+
+	if err := resolveCompositeKeys(objectNames, attrsByBean); err != nil {
+		return fmt.Errorf("failed to query composite attribute keys: %w", err)
+	}
+
+	beansByDomain := make(map[string]map[string][]*attributeOutput)
+	for _, objectName := range objectNames {
+		domainAndQuery := strings.SplitN(objectName, ":", 2)
+		if len(domainAndQuery) != 2 {
+			continue
+		}
+		domain, query := domainAndQuery[0], domainAndQuery[1]
+
+		var outAttrs []*attributeOutput
+		for _, attr := range attrsByBean[objectName] {
+			outAttrs = append(outAttrs, expandAttribute(attr)...)
+		}
+
+		if beansByDomain[domain] == nil {
+			beansByDomain[domain] = make(map[string][]*attributeOutput)
+		}
+		beansByDomain[domain][query] = append(beansByDomain[domain][query], outAttrs...)
+	}
+
+	return writeIntrospectOutput(buildIntrospectDomains(beansByDomain))
+}
+
+// listMBeanNames runs jmxterm's "beans" command and returns every object
+// name it reports.
+func listMBeanNames() ([]string, error) {
+	output, err := jmxtermCommandFunc(jmxtermOpenCommand() + "beans\nclose\n")
+	if err != nil {
+		return nil, err
+	}
+	return parseJmxtermBeans(output), nil
+}
+
+func parseJmxtermBeans(output string) []string {
+	var names []string
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if jmxtermBeanPattern.MatchString(line) {
+			names = append(names, line)
+		}
+	}
+	return names
+}
+
+// describeMBeans runs a single jmxterm session issuing "info -b" for every
+// discovered bean and returns each bean's attributes.
+func describeMBeans(objectNames []string) (map[string][]mBeanAttribute, error) {
+	if len(objectNames) == 0 {
+		return nil, nil
+	}
+
+	var script strings.Builder
+	script.WriteString(jmxtermOpenCommand())
+	for _, name := range objectNames {
+		fmt.Fprintf(&script, "info -b %s\n", name)
+	}
+	script.WriteString("close\n")
+
+	output, err := jmxtermCommandFunc(script.String())
+	if err != nil {
+		return nil, err
+	}
+
+	return parseJmxtermInfo(output, objectNames), nil
+}
+
+// parseJmxtermInfo splits a multi-bean "info -b" transcript back out by
+// bean, in the order objectNames were requested in, since jmxterm's output
+// has no per-bean delimiter of its own beyond the "#mbean = " line each
+// "info" call starts with.
+func parseJmxtermInfo(output string, objectNames []string) map[string][]mBeanAttribute {
+	sections := splitOnMBeanHeader(output)
+	attrsByBean := make(map[string][]mBeanAttribute, len(objectNames))
+
+	for i, section := range sections {
+		if i >= len(objectNames) {
+			break
+		}
+
+		var attrs []mBeanAttribute
+		inAttributes := false
+		for _, line := range strings.Split(section, "\n") {
+			trimmed := strings.TrimSpace(line)
+			switch trimmed {
+			case "ATTRIBUTES":
+				inAttributes = true
+				continue
+			case "OPERATIONS", "NOTIFICATIONS":
+				inAttributes = false
+				continue
+			}
+			if !inAttributes {
+				continue
+			}
+
+			m := jmxtermAttrPattern.FindStringSubmatch(line)
+			if m == nil {
+				continue
+			}
+			attrs = append(attrs, mBeanAttribute{Name: m[1], Type: m[2]})
+		}
+
+		attrsByBean[objectNames[i]] = attrs
+	}
+
+	return attrsByBean
+}
+
+func splitOnMBeanHeader(output string) []string {
+	const header = "#mbean = "
+	var sections []string
+	for {
+		idx := strings.Index(output, header)
+		if idx == -1 {
+			break
+		}
+		output = output[idx+len(header):]
+		next := strings.Index(output, header)
+		if next == -1 {
+			sections = append(sections, output)
+			break
+		}
+		sections = append(sections, output[:next])
+		output = output[next:]
+	}
+	return sections
+}
+
+// resolveCompositeKeys fills in CompositeKeys for every CompositeData
+// attribute found, by issuing a "get -b" for each one and parsing the
+// key/value block jmxterm prints back.
+func resolveCompositeKeys(objectNames []string, attrsByBean map[string][]mBeanAttribute) error {
+	type compositeRef struct {
+		bean string
+		idx  int
+	}
+
+	var refs []compositeRef
+	var script strings.Builder
+	script.WriteString(jmxtermOpenCommand())
+
+	for _, bean := range objectNames {
+		attrs := attrsByBean[bean]
+		for i, attr := range attrs {
+			if attr.Type != "javax.management.openmbean.CompositeData" {
+				continue
+			}
+			refs = append(refs, compositeRef{bean: bean, idx: i})
+			fmt.Fprintf(&script, "get -b %s %s\n", bean, attr.Name)
+		}
+	}
+
+	if len(refs) == 0 {
+		return nil
+	}
+	script.WriteString("close\n")
+
+	output, err := jmxtermCommandFunc(script.String())
+	if err != nil {
+		return err
+	}
+
+	blocks := strings.Split(output, "{")
+	if len(blocks) < len(refs)+1 {
+		log.Warn("Expected %d composite attribute results, got %d; composite keys may be incomplete", len(refs), len(blocks)-1)
+	}
+
+	for i, ref := range refs {
+		if i+1 >= len(blocks) {
+			break
+		}
+		block := blocks[i+1]
+		if end := strings.Index(block, "}"); end != -1 {
+			block = block[:end]
+		}
+
+		var keys []string
+		for _, line := range strings.Split(block, ";") {
+			if m := jmxtermCompositeKeyPattern.FindStringSubmatch(line); m != nil {
+				keys = append(keys, m[1])
+			}
+		}
+
+		attrsByBean[ref.bean][ref.idx].CompositeKeys = keys
+	}
+
+	return nil
+}
+
+// expandAttribute turns a single MBean attribute into one or more
+// attributeOutput entries, expanding CompositeData one level deep (e.g.
+// HeapMemoryUsage.used) since nri-jmx can't query nested fields directly.
+func expandAttribute(attr mBeanAttribute) []*attributeOutput {
+	if len(attr.CompositeKeys) > 0 {
+		out := make([]*attributeOutput, 0, len(attr.CompositeKeys))
+		for _, key := range attr.CompositeKeys {
+			name := attr.Name + "." + key
+			out = append(out, &attributeOutput{Attr: name, MetricType: "gauge", MetricName: name})
+		}
+		return out
+	}
+
+	return []*attributeOutput{{
+		Attr:       attr.Name,
+		MetricType: classifyMetricType(attr),
+		MetricName: attr.Name,
+	}}
+}
+
+func classifyMetricType(attr mBeanAttribute) string {
+	if !numericJavaTypes[attr.Type] {
+		return "attribute"
+	}
+	if attr.Type == "java.util.concurrent.atomic.LongAdder" || counterNamePattern.MatchString(attr.Name) {
+		return "delta"
+	}
+	return "gauge"
+}
+
+// buildIntrospectDomains sorts the discovered domains/beans/attrs so that
+// regenerating the file against an unchanged endpoint produces a clean diff.
+func buildIntrospectDomains(beansByDomain map[string]map[string][]*attributeOutput) []*domainOutput {
+	var domains []*domainOutput
+	for domain, beans := range beansByDomain {
+		var beanList []*beanOutput
+		for query, attrs := range beans {
+			sort.Slice(attrs, func(i, j int) bool { return attrs[i].Attr < attrs[j].Attr })
+			beanList = append(beanList, &beanOutput{Query: query, Attributes: attrs})
+		}
+		sort.Slice(beanList, func(i, j int) bool { return beanList[i].Query < beanList[j].Query })
+
+		domains = append(domains, &domainOutput{
+			Domain:    domain,
+			EventType: makeInsightsCompliantEventType(domain),
+			Beans:     beanList,
+		})
+	}
+	sort.Slice(domains, func(i, j int) bool { return domains[i].Domain < domains[j].Domain })
+	return domains
+}
+
+// writeIntrospectOutput marshals the generated collection to YAML and
+// writes it to args.IntrospectOutput, or stdout if unset.
+func writeIntrospectOutput(domains []*domainOutput) error {
+	m, err := yaml.Marshal(&collectOutput{Collect: domains})
+	if err != nil {
+		return err
+	}
+
+	if args.IntrospectOutput == "" {
+		fmt.Printf("%s", string(m))
+		return nil
+	}
+
+	return ioutil.WriteFile(args.IntrospectOutput, m, 0644)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// realisticJmxtermInfoTranscript is a literal two-bean "info -b" transcript
+// in jmxterm's actual output shape: section banners are bare ALL-CAPS lines
+// (ATTRIBUTES/OPERATIONS/NOTIFICATIONS) with no leading "#" — only the
+// "#mbean = " header line itself is "#"-prefixed.
+const realisticJmxtermInfoTranscript = `#mbean = java.lang:type=Memory:
+CLASS NAME
+	javax.management.MBeanInfo
+ATTRIBUTES
+	%0   - HeapMemoryUsage (javax.management.openmbean.CompositeData, r)
+	%1   - ObjectPendingFinalizationCount (int, r)
+	%2   - Verbose (boolean, rw)
+OPERATIONS
+	%0   - void gc()
+NOTIFICATIONS
+	javax.management.Notification
+	NOTIF TYPES
+		java.management.memory.threshold.exceeded
+#mbean = java.lang:type=Threading:
+CLASS NAME
+	javax.management.MBeanInfo
+ATTRIBUTES
+	%0   - ThreadCount (int, r)
+	%1   - TotalStartedThreadCount (long, r)
+OPERATIONS
+	%0   - long[] getAllThreadIds()
+`
+
+func TestParseJmxtermInfo(t *testing.T) {
+	objectNames := []string{"java.lang:type=Memory", "java.lang:type=Threading"}
+
+	got := parseJmxtermInfo(realisticJmxtermInfoTranscript, objectNames)
+
+	want := map[string][]mBeanAttribute{
+		"java.lang:type=Memory": {
+			{Name: "HeapMemoryUsage", Type: "javax.management.openmbean.CompositeData"},
+			{Name: "ObjectPendingFinalizationCount", Type: "int"},
+			{Name: "Verbose", Type: "boolean"},
+		},
+		"java.lang:type=Threading": {
+			{Name: "ThreadCount", Type: "int"},
+			{Name: "TotalStartedThreadCount", Type: "long"},
+		},
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseJmxtermInfo(...) = %+v, want %+v", got, want)
+	}
+}
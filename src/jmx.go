@@ -1,26 +1,41 @@
 package main
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
 
 	sdkArgs "github.com/newrelic/infra-integrations-sdk/args"
 	"github.com/newrelic/infra-integrations-sdk/integration"
 	"github.com/newrelic/infra-integrations-sdk/jmx"
 	"github.com/newrelic/infra-integrations-sdk/log"
+	"github.com/newrelic/nri-jmx/src/discover"
 )
 
 type argumentList struct {
 	sdkArgs.DefaultArgumentList
-	ConvertFile     string `default:"false" help:"Set to true if you want to convert a JMX config file from the New Relic Java Agent"`
-	JmxHost         string `default:"localhost" help:"The host running JMX"`
-	JmxPort         string `default:"9999" help:"The port JMX is running on"`
-	JmxUser         string `default:"admin" help:"The username for the JMX connection"`
-	JmxPass         string `default:"admin" help:"The password for the JMX connection"`
-	CollectionFiles string `default:"" help:"A comma separated list of full paths to metrics configuration files"`
-	Timeout         int    `default:"10000" help:"Timeout for JMX queries"`
-	MetricLimit     int    `default:"200" help:"Number of metrics that can be collected per entity. If this limit is exceeded the entity will not be reported. A limit of 0 implies no limit."`
+	ConvertFile      string `default:"false" help:"Set to true if you want to convert a JMX config file from the New Relic Java Agent"`
+	JmxHost          string `default:"localhost" help:"The host running JMX"`
+	JmxPort          string `default:"9999" help:"The port JMX is running on"`
+	JmxUser          string `default:"admin" help:"The username for the JMX connection"`
+	JmxPass          string `default:"admin" help:"The password for the JMX connection"`
+	CollectionFiles  string `default:"" help:"A comma separated list of full paths to metrics configuration files. When --discover-local is set, an entry may instead be path=match, where match is a regular expression tested against a discovered JVM's main class so that entry is only run against matching JVMs"`
+	Timeout          int    `default:"10000" help:"Timeout for JMX queries"`
+	MetricLimit      int    `default:"200" help:"Number of metrics that can be collected per entity. If this limit is exceeded the entity will not be reported. A limit of 0 implies no limit."`
+	DiscoverLocal    string `default:"false" help:"Set to true to discover and collect from every JVM running on this host instead of connecting to JmxHost/JmxPort"`
+	DiscoverMatch    string `default:"" help:"A regular expression matched against each discovered JVM's main class; only matching JVMs are collected when --discover-local is set. Used as the default for any CollectionFiles entry that doesn't specify its own path=match"`
+	TargetsFile      string `default:"" help:"Path to a YAML file listing multiple JMX targets to collect from in parallel, instead of connecting to JmxHost/JmxPort"`
+	Concurrency      int    `default:"4" help:"Maximum number of targets from TargetsFile to collect from at once"`
+	Introspect       string `default:"false" help:"Set to true to connect to JmxHost/JmxPort, enumerate every MBean it exposes, and print a ready-to-edit collection file"`
+	IntrospectOutput string `default:"" help:"Path to write the generated collection file to when --introspect is set. Defaults to stdout"`
+	Vars             string `default:"" help:"A comma separated list of key=value pairs available to \\${VAR} interpolation in collection files, taking priority over the process environment"`
+	RenderConfig     string `default:"false" help:"Set to true to print the fully interpolated and merged collection files and exit"`
+	Exporter         string `default:"newrelic" help:"Comma separated list of exporters to publish metrics to: newrelic, prometheus, otlp"`
+	PromListen       string `default:":9404" help:"Address the prometheus exporter listens on when --exporter includes prometheus"`
+	OTLPEndpoint     string `default:"" help:"OTLP/HTTP metrics endpoint to post to when --exporter includes otlp"`
+	Interval         int    `default:"0" help:"Re-run collection every Interval seconds instead of exiting after one pass. Only useful with a long-running exporter like prometheus"`
 }
 
 const (
@@ -44,12 +59,33 @@ func main() {
 	}
 	log.SetupLogging(args.Verbose)
 
-	// Ensure a collection file is specified
-	if args.CollectionFiles == "" {
+	if args.Introspect == "true" {
+		// Introspection drives jmxterm directly (see introspect.go) rather
+		// than the nrjmx connection jmxOpenFunc/jmxCloseFunc manage below,
+		// since nrjmx's Open/Close/Query don't expose MBeanInfo metadata.
+		if err := runIntrospect(); err != nil {
+			log.Error("Introspection failed: %s", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	// A --targets-file can supply collection_files per target instead, so
+	// CollectionFiles is only required on the single-host/discover-local
+	// paths.
+	if args.CollectionFiles == "" && args.TargetsFile == "" {
 		log.Error("Must specify at least one collection file")
 		os.Exit(1)
 	}
 
+	if args.RenderConfig == "true" {
+		if err := renderConfig(); err != nil {
+			log.Error("Failed to render config: %s", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
 	if args.ConvertFile == "true" {
 		log.Info("Converting " + args.CollectionFiles + " to nri-jmx format")
 
@@ -104,52 +140,168 @@ func main() {
 		os.Exit(0)
 	}
 
-	// Open a JMX connection
-	if err := jmxOpenFunc(args.JmxHost, args.JmxPort, args.JmxUser, args.JmxPass); err != nil {
-		log.Error(
-			"Failed to open JMX connection (host: %s, port: %s, user: %s, pass: %s): %s",
-			args.JmxHost, args.JmxPort, args.JmxUser, args.JmxPass, err,
-		)
+	if err := runPass(jmxIntegration); err != nil {
+		log.Error("Collection failed: %s", err)
 		os.Exit(1)
 	}
 
-	// For each collection definition file, parse and collect it
-	collectionFiles := strings.Split(args.CollectionFiles, ",")
-	for _, collectionFile := range collectionFiles {
+	jmxIntegration.Entities = checkMetricLimit(jmxIntegration.Entities)
+
+	exporters, err := buildExporters(jmxIntegration)
+	if err != nil {
+		log.Error("Failed to configure exporters: %s", err)
+		os.Exit(1)
+	}
+
+	exportEntities(exporters, jmxIntegration.Entities)
+
+	if requiresInterval(args.Exporter) && args.Interval <= 0 {
+		log.Error("--exporter=%s requires --interval>0: prometheus only serves metrics for as long as the process stays running, and it would otherwise exit right after this one collection pass", args.Exporter)
+		os.Exit(1)
+	}
 
-		// Check that the filepath is an absolute path
-		if !filepath.IsAbs(collectionFile) {
-			log.Error("Invalid metrics collection path %s. Metrics collection files must be specified as absolute paths.", collectionFile)
+	if args.Interval > 0 {
+		if !hasLongRunningExporter(args.Exporter) {
+			log.Error("--interval is only useful with a long-running exporter like prometheus or otlp; --exporter=%s would just publish the same pass forever", args.Exporter)
 			os.Exit(1)
 		}
+		runCollectionLoop(exporters)
+	}
+}
 
-		// Parse the yaml file into a raw definition
-		collectionDefinition, err := parseYaml(collectionFile)
-		if err != nil {
-			log.Error("Failed to parse collection definition file %s: %s", collectionFile, err)
-			os.Exit(1)
+// discoveryCollectionFile is one entry of CollectionFiles as used by
+// --discover-local: a collection file plus the regular expression a
+// discovered JVM's main class must match for that file to run against it.
+// A nil Match means "run against every discovered JVM".
+type discoveryCollectionFile struct {
+	Path  string
+	Match *regexp.Regexp
+}
+
+// parseDiscoveryCollectionFiles parses CollectionFiles for --discover-local,
+// where an entry may be a bare path or a path=match pair, so different
+// collection files can be routed to different discovered JVMs by main-class
+// pattern. An entry with no =match clause falls back to defaultMatch
+// (--discover-match), if any.
+func parseDiscoveryCollectionFiles(spec, defaultMatch string) ([]discoveryCollectionFile, error) {
+	defaultRegexp, err := compileMatch(defaultMatch)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --discover-match pattern: %w", err)
+	}
+
+	var files []discoveryCollectionFile
+	for _, entry := range strings.Split(spec, ",") {
+		path := entry
+		matchRegexp := defaultRegexp
+
+		if idx := strings.Index(entry, "="); idx != -1 {
+			path = entry[:idx]
+			matchRegexp, err = compileMatch(entry[idx+1:])
+			if err != nil {
+				return nil, fmt.Errorf("invalid match clause for %s: %w", path, err)
+			}
 		}
 
-		// Validate the definition and create a collection object
-		collection, err := parseCollectionDefinition(collectionDefinition)
+		files = append(files, discoveryCollectionFile{Path: path, Match: matchRegexp})
+	}
+
+	return files, nil
+}
+
+func compileMatch(pattern string) (*regexp.Regexp, error) {
+	if pattern == "" {
+		return nil, nil
+	}
+	return regexp.Compile(pattern)
+}
+
+// runLocalDiscovery enumerates every JVM running on the host the same way
+// jps does, resolves (starting the management agent if necessary) a local
+// JMX connector for each one, and runs every configured collection file
+// whose match pattern accepts it against that connector. Each JVM is
+// reported as its own entity, tagged with its PID and main class, rather
+// than requiring JmxHost/JmxPort to be pre-configured.
+//
+// jmx.Open/jmx.Close only support JmxHost/JmxPort, not an arbitrary
+// connector URL, so discovery opens its own nrjmx subprocess per JVM via
+// openJMXSubprocessURLFunc (see jmxclient.go) rather than the global
+// connection. Discovery still runs one JVM at a time, so the before/rename
+// pattern below needs no locking the way targets.go's concurrent version
+// does.
+func runLocalDiscovery(jmxIntegration *integration.Integration) error {
+	jvms, err := discover.ScanLocalJVMs()
+	if err != nil {
+		return err
+	}
+
+	collectionFiles, err := parseDiscoveryCollectionFiles(args.CollectionFiles, args.DiscoverMatch)
+	if err != nil {
+		return err
+	}
+
+	for _, jvm := range jvms {
+		matching := make([]discoveryCollectionFile, 0, len(collectionFiles))
+		for _, cf := range collectionFiles {
+			if cf.Match == nil || cf.Match.MatchString(jvm.MainClass) {
+				matching = append(matching, cf)
+			}
+		}
+		if len(matching) == 0 {
+			continue
+		}
+
+		connectorURL, err := discover.ResolveConnectorURL(jvm.PID)
 		if err != nil {
-			log.Error("Failed to parse collection definition %s: %s", collectionFile, err)
-			os.Exit(1)
+			log.Error("Skipping pid %d (%s): %s", jvm.PID, jvm.MainClass, err)
+			continue
 		}
 
-		if err := runCollection(collection, jmxIntegration); err != nil {
-			log.Error("Failed to complete collection: %s", err)
+		client, err := openJMXSubprocessURLFunc(connectorURL, args.JmxUser, args.JmxPass)
+		if err != nil {
+			log.Error("Failed to open JMX connection to pid %d (%s): %s", jvm.PID, jvm.MainClass, err)
+			continue
 		}
-	}
 
-	jmxCloseFunc()
+		before := len(jmxIntegration.Entities)
 
-	jmxIntegration.Entities = checkMetricLimit(jmxIntegration.Entities)
+		for _, cf := range matching {
+			if !filepath.IsAbs(cf.Path) {
+				log.Error("Invalid metrics collection path %s. Metrics collection files must be specified as absolute paths.", cf.Path)
+				continue
+			}
 
-	if err := jmxIntegration.Publish(); err != nil {
-		log.Error("Failed to publish integration: %s", err.Error())
-		os.Exit(1)
+			collectionDefinition, err := parseYaml(cf.Path)
+			if err != nil {
+				log.Error("Failed to parse collection definition file %s: %s", cf.Path, err)
+				continue
+			}
+
+			collection, err := parseCollectionDefinition(collectionDefinition)
+			if err != nil {
+				log.Error("Failed to parse collection definition %s: %s", cf.Path, err)
+				continue
+			}
+
+			if err := runCollectionWithQuery(collection, jmxIntegration, client.Query); err != nil {
+				log.Error("Failed to complete collection for pid %d (%s): %s", jvm.PID, jvm.MainClass, err)
+			}
+		}
+
+		for _, entity := range jmxIntegration.Entities[before:] {
+			entity.Metadata.Name = namespacedJVMEntityName(entity.Metadata.Name, jvm)
+		}
+
+		client.Close()
 	}
+
+	return nil
+}
+
+// namespacedJVMEntityName prefixes an entity name with the discovered JVM's
+// PID and main class so two JVMs exposing the same domain (e.g. java.lang,
+// true of virtually any two JVMs) don't collapse onto the same entity.
+func namespacedJVMEntityName(name string, jvm discover.JVM) string {
+	return fmt.Sprintf("pid:%d/%s/%s", jvm.PID, jvm.MainClass, name)
 }
 
 // checkMetricLimit looks through all of the metric sets for every entity and aggregates the number
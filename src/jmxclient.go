@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/newrelic/infra-integrations-sdk/log"
+)
+
+// jmxSubprocessClient is a standalone nrjmx connection, independent of the
+// single global subprocess jmxOpenFunc/jmxCloseFunc/jmxQueryFunc wrap.
+// jmx.Open/Close/Query aren't reentrant, so collecting from multiple targets
+// at once (see targets.go), or from a local JVM via its own connector URL
+// (see jmx.go's runLocalDiscovery), needs one of these per connection
+// rather than sharing the package-level connection.
+//
+// readLoop is the only goroutine that ever reads the subprocess's stdout;
+// Query writes a request and waits on lines/readErr rather than spawning
+// its own reader. A per-call reader would leave its read outstanding after
+// a timeout, and that orphaned read would eventually deliver the previous
+// query's response to whichever Query call happened to be waiting next.
+type jmxSubprocessClient struct {
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	lines   chan string
+	readErr chan error
+
+	// owedResponses counts responses that a previous Query call timed out
+	// on but that readLoop hasn't delivered yet. nrjmx answers queries
+	// strictly in the order they were sent, so the next owedResponses
+	// lines delivered on lines belong to those abandoned queries, not to
+	// whichever Query call happens to be waiting next.
+	owedResponses int
+}
+
+// openJMXSubprocessFunc is a variable, the same convention jmx.go uses for
+// jmxOpenFunc, so callers' tests (if any) can stub out spawning a real
+// nrjmx process.
+var openJMXSubprocessFunc = openJMXSubprocess
+
+// openJMXSubprocess spawns an independent nrjmx process connected to
+// host:port, the same binary jmxOpenFunc ultimately shells out to, and
+// leaves it running so Query can be called against it repeatedly.
+func openJMXSubprocess(host, port, user, pass string) (*jmxSubprocessClient, error) {
+	return startJMXSubprocess(exec.Command("nrjmx", "-hostname", host, "-port", port, "-username", user, "-password", pass))
+}
+
+// openJMXSubprocessURLFunc is a variable for the same reason as
+// openJMXSubprocessFunc.
+var openJMXSubprocessURLFunc = openJMXSubprocessURL
+
+// openJMXSubprocessURL spawns an independent nrjmx process connected to a
+// full JMX service URL (e.g. the local connector address
+// discover.ResolveConnectorURL resolves), rather than a plain host:port.
+// jmx.Open/jmx.OpenURL don't support this — the vendored SDK only exposes a
+// single, host/port-only, non-reentrant global connection.
+func openJMXSubprocessURL(serviceURL, user, pass string) (*jmxSubprocessClient, error) {
+	return startJMXSubprocess(exec.Command("nrjmx", "-jmxServiceURL", serviceURL, "-username", user, "-password", pass))
+}
+
+func startJMXSubprocess(cmd *exec.Cmd) (*jmxSubprocessClient, error) {
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open nrjmx stdin: %w", err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open nrjmx stdout: %w", err)
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start nrjmx: %w", err)
+	}
+
+	client := &jmxSubprocessClient{
+		cmd:     cmd,
+		stdin:   stdin,
+		lines:   make(chan string, 1),
+		readErr: make(chan error, 1),
+	}
+	go client.readLoop(stdout)
+
+	return client, nil
+}
+
+// readLoop owns stdout for the lifetime of the subprocess, feeding each line
+// it reads to lines. It exits and closes lines on the first read error
+// (including EOF once the subprocess exits), delivering that error on
+// readErr for Query to surface.
+func (c *jmxSubprocessClient) readLoop(stdout io.Reader) {
+	reader := bufio.NewReader(stdout)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			c.readErr <- err
+			close(c.lines)
+			return
+		}
+		c.lines <- line
+	}
+}
+
+// Query writes a single bean query to the subprocess and reads back the
+// JSON-encoded attribute/value map it responds with, the same line-oriented
+// protocol jmx.Query speaks to the global nrjmx subprocess.
+func (c *jmxSubprocessClient) Query(query string, timeoutMs int) (map[string]interface{}, error) {
+	// A previous call (or several) may have timed out while readLoop's
+	// response for it was still in flight. Drain exactly as many
+	// responses as are owed, blocking until they actually arrive, before
+	// sending a new request — a one-shot, non-blocking drain isn't enough
+	// because the stale response may still be in flight rather than
+	// already sitting in lines.
+	if err := c.drainOwedResponses(); err != nil {
+		return nil, err
+	}
+
+	if _, err := fmt.Fprintf(c.stdin, "%s\n", query); err != nil {
+		return nil, fmt.Errorf("failed to write query: %w", err)
+	}
+
+	select {
+	case line, ok := <-c.lines:
+		if !ok {
+			return nil, fmt.Errorf("nrjmx connection closed: %w", <-c.readErr)
+		}
+		var values map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &values); err != nil {
+			return nil, fmt.Errorf("failed to decode query result: %w", err)
+		}
+		return values, nil
+	case <-time.After(time.Duration(timeoutMs) * time.Millisecond):
+		c.owedResponses++
+		return nil, fmt.Errorf("query %s timed out after %dms", query, timeoutMs)
+	}
+}
+
+// drainOwedResponses blocks until every response owed by a previously
+// timed-out query has been read and discarded, so the next line readLoop
+// delivers is guaranteed to be this call's own response rather than one
+// misattributed to it. nrjmx answers queries strictly in the order they
+// were sent, so draining exactly the number owed — no more, no less —
+// keeps requests and responses correlated without needing a sequence id
+// the line-oriented protocol doesn't carry.
+func (c *jmxSubprocessClient) drainOwedResponses() error {
+	for c.owedResponses > 0 {
+		line, ok := <-c.lines
+		if !ok {
+			return fmt.Errorf("nrjmx connection closed while draining a stale response: %w", <-c.readErr)
+		}
+		log.Warn("Discarding stale nrjmx response left over from a previous timed-out query: %s", strings.TrimSpace(line))
+		c.owedResponses--
+	}
+	return nil
+}
+
+// Close stops the subprocess.
+func (c *jmxSubprocessClient) Close() error {
+	c.stdin.Close()
+	return c.cmd.Wait()
+}
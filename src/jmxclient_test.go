@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os/exec"
+	"reflect"
+	"testing"
+)
+
+// discardWriteCloser satisfies io.WriteCloser without doing anything, so
+// tests can build a jmxSubprocessClient without a real nrjmx subprocess
+// behind it.
+type discardWriteCloser struct{}
+
+func (discardWriteCloser) Write(p []byte) (int, error) { return len(p), nil }
+func (discardWriteCloser) Close() error                { return nil }
+
+// newFakeJMXSubprocessClient builds a jmxSubprocessClient that behaves like
+// Query's caller expects without a real nrjmx subprocess behind it. cmd is a
+// harmless unstarted command only so Close (which calls cmd.Wait) has
+// something non-nil to call.
+func newFakeJMXSubprocessClient() *jmxSubprocessClient {
+	return &jmxSubprocessClient{
+		cmd:     exec.Command("true"),
+		stdin:   discardWriteCloser{},
+		lines:   make(chan string, 4),
+		readErr: make(chan error, 1),
+	}
+}
+
+func TestQueryTimeoutRecordsAnOwedResponse(t *testing.T) {
+	client := newFakeJMXSubprocessClient()
+
+	// Nothing is ever sent on lines, so this call always times out.
+	if _, err := client.Query("domain:type=Foo", 1); err == nil {
+		t.Fatal("expected Query to time out, got nil error")
+	}
+
+	if client.owedResponses != 1 {
+		t.Errorf("owedResponses = %d, want 1", client.owedResponses)
+	}
+}
+
+// TestQueryDrainsOwedResponseBeforeMatchingNewQuery is a regression test for
+// the stale-response race: a response readLoop delivers after its query
+// already timed out must never be handed back as the result of whichever
+// Query call is waiting next. It must be drained and discarded first, no
+// matter when it actually arrives relative to the new request being sent.
+func TestQueryDrainsOwedResponseBeforeMatchingNewQuery(t *testing.T) {
+	client := newFakeJMXSubprocessClient()
+	client.owedResponses = 1
+
+	// The abandoned query's response is still queued ahead of the new
+	// query's own response, exactly like readLoop would deliver them.
+	client.lines <- `{"stale":true}` + "\n"
+	client.lines <- fmt.Sprintf("%s\n", `{"domain:type=Foo,Attr":42}`)
+
+	values, err := client.Query("domain:type=Foo", 1000)
+	if err != nil {
+		t.Fatalf("Query returned error: %s", err)
+	}
+
+	if client.owedResponses != 0 {
+		t.Errorf("owedResponses = %d, want 0 after draining", client.owedResponses)
+	}
+
+	want := map[string]interface{}{"domain:type=Foo,Attr": 42.0}
+	if !reflect.DeepEqual(values, want) {
+		t.Errorf("Query(...) = %+v, want %+v", values, want)
+	}
+}
+
+func TestQueryReportsConnectionClosedWhileDrainingOwedResponse(t *testing.T) {
+	client := newFakeJMXSubprocessClient()
+	client.owedResponses = 1
+	client.readErr <- io.EOF
+	close(client.lines)
+
+	if _, err := client.Query("domain:type=Foo", 1000); err == nil {
+		t.Fatal("expected Query to report the connection closing while draining, got nil error")
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/newrelic/infra-integrations-sdk/integration"
+	"github.com/newrelic/infra-integrations-sdk/log"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// collectionTarget is a single JMX endpoint described in a --targets-file.
+type collectionTarget struct {
+	Host            string            `yaml:"host"`
+	Port            string            `yaml:"port"`
+	User            string            `yaml:"user"`
+	Pass            string            `yaml:"pass"`
+	CollectionFiles []string          `yaml:"collection_files"`
+	Labels          map[string]string `yaml:"labels"`
+}
+
+type targetsFile struct {
+	Targets []collectionTarget `yaml:"targets"`
+}
+
+// loadTargetsFile parses a --targets-file document into its targets.
+func loadTargetsFile(filename string) (*targetsFile, error) {
+	raw, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var tf targetsFile
+	if err := yaml.Unmarshal(raw, &tf); err != nil {
+		return nil, err
+	}
+	if len(tf.Targets) == 0 {
+		return nil, fmt.Errorf("%s: no targets defined", filename)
+	}
+
+	return &tf, nil
+}
+
+// runTargetsCollection fans out collection across every target in
+// args.TargetsFile, running up to args.Concurrency of them at once. Each
+// target collects through its own nrjmx subprocess (see jmxclient.go) into
+// its own throwaway integration, so the targets genuinely run in parallel
+// rather than queuing behind one shared connection; entitiesMutex only
+// guards the brief final append of each target's finished entities onto the
+// shared result. A target failing to collect does not abort the others;
+// its error is recorded as an inventory item on a synthetic entity instead.
+func runTargetsCollection(jmxIntegration *integration.Integration) error {
+	tf, err := loadTargetsFile(args.TargetsFile)
+	if err != nil {
+		return err
+	}
+
+	concurrency := args.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	var (
+		entitiesMutex sync.Mutex
+		wg            sync.WaitGroup
+		sem           = make(chan struct{}, concurrency)
+	)
+
+	for _, target := range tf.Targets {
+		wg.Add(1)
+		go func(target collectionTarget) {
+			defer wg.Done()
+
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			if err := collectTarget(target, jmxIntegration, &entitiesMutex); err != nil {
+				log.Error("Target %s:%s failed to collect: %s", target.Host, target.Port, err)
+				reportTargetError(jmxIntegration, target, err, &entitiesMutex)
+			}
+		}(target)
+	}
+
+	wg.Wait()
+	return nil
+}
+
+// collectTarget opens its own nrjmx subprocess against a single target and
+// runs every configured collection file against it into a local, throwaway
+// integration.Integration — so the actual collection work needs no locking
+// at all, and can run concurrently with every other target's. Only the
+// final append of this target's namespaced entities onto the shared
+// jmxIntegration is serialized, via entitiesMutex.
+func collectTarget(target collectionTarget, jmxIntegration *integration.Integration, entitiesMutex *sync.Mutex) error {
+	user := target.User
+	if user == "" {
+		user = args.JmxUser
+	}
+	pass := target.Pass
+	if pass == "" {
+		pass = args.JmxPass
+	}
+
+	client, err := openJMXSubprocessFunc(target.Host, target.Port, user, pass)
+	if err != nil {
+		return fmt.Errorf("failed to open JMX connection: %w", err)
+	}
+	defer client.Close()
+
+	// Deliberately built without integration.Args(&args): the SDK registers
+	// argumentList's fields as global command-line flags the first time
+	// they're parsed (the top-level jmxIntegration in jmx.go's main), and
+	// registering the same flag names again panics. localIntegration never
+	// parses its own arguments or gets published directly — it's a
+	// throwaway holder for this target's entities until they're merged
+	// into jmxIntegration below — so it doesn't need them.
+	localIntegration, err := integration.New(integrationName, integrationVersion)
+	if err != nil {
+		return fmt.Errorf("failed to set up local integration: %w", err)
+	}
+
+	collectionFiles := target.CollectionFiles
+	if len(collectionFiles) == 0 {
+		collectionFiles = strings.Split(args.CollectionFiles, ",")
+	}
+
+	for _, collectionFile := range collectionFiles {
+		if !filepath.IsAbs(collectionFile) {
+			return fmt.Errorf("invalid metrics collection path %s: must be an absolute path", collectionFile)
+		}
+
+		collectionDefinition, err := parseYaml(collectionFile)
+		if err != nil {
+			return fmt.Errorf("failed to parse collection definition file %s: %w", collectionFile, err)
+		}
+
+		collection, err := parseCollectionDefinition(collectionDefinition)
+		if err != nil {
+			return fmt.Errorf("failed to parse collection definition %s: %w", collectionFile, err)
+		}
+
+		if err := runCollectionWithQuery(collection, localIntegration, client.Query); err != nil {
+			return fmt.Errorf("failed to complete collection %s: %w", collectionFile, err)
+		}
+	}
+
+	newEntities := checkMetricLimit(localIntegration.Entities)
+	for _, entity := range newEntities {
+		entity.Metadata.Name = namespacedEntityName(entity.Metadata.Name, target)
+	}
+
+	entitiesMutex.Lock()
+	jmxIntegration.Entities = append(jmxIntegration.Entities, newEntities...)
+	entitiesMutex.Unlock()
+
+	return nil
+}
+
+// reportTargetError records a target's collection failure as an inventory
+// item on a synthetic entity, rather than failing the whole run.
+func reportTargetError(jmxIntegration *integration.Integration, target collectionTarget, collectErr error, entitiesMutex *sync.Mutex) {
+	entitiesMutex.Lock()
+	defer entitiesMutex.Unlock()
+
+	entityName := fmt.Sprintf("%s:%s", target.Host, target.Port)
+	entity, err := jmxIntegration.Entity(entityName, "jmx-target-error")
+	if err != nil {
+		log.Error("Failed to create error entity for target %s: %s", entityName, err)
+		return
+	}
+
+	if err := entity.SetInventoryItem("collection", "error", collectErr.Error()); err != nil {
+		log.Error("Failed to record collection error for target %s: %s", entityName, err)
+	}
+}
+
+// namespacedEntityName prefixes an entity name with its target's
+// host:port and appends its labels, sorted by key, as ",key=value", so
+// metrics from different JVMs don't collide.
+func namespacedEntityName(name string, target collectionTarget) string {
+	namespaced := fmt.Sprintf("%s:%s/%s", target.Host, target.Port, name)
+	if len(target.Labels) == 0 {
+		return namespaced
+	}
+
+	keys := make([]string, 0, len(target.Labels))
+	for k := range target.Labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString(namespaced)
+	for _, k := range keys {
+		fmt.Fprintf(&b, ",%s=%s", k, target.Labels[k])
+	}
+	return b.String()
+}
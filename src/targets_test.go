@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/newrelic/infra-integrations-sdk/integration"
+)
+
+const targetsTestCollectionYAML = `
+collect:
+  - domain: java.lang
+    event_type: JVMSample
+    beans:
+      - query: type=Memory
+        attributes:
+          - attr: HeapMemoryUsage
+            metric_type: gauge
+            metric_name: jvm.heap.used
+`
+
+// newCannedJMXSubprocessClient builds a jmxSubprocessClient whose Query
+// responses are pre-loaded onto its lines channel, so runCollectionWithQuery
+// can drive it exactly like a real nrjmx subprocess without shelling out to
+// one.
+func newCannedJMXSubprocessClient(responses ...string) *jmxSubprocessClient {
+	client := newFakeJMXSubprocessClient()
+	for _, r := range responses {
+		client.lines <- r + "\n"
+	}
+	return client
+}
+
+// TestRunTargetsCollectionFansOutInParallel is a regression test for
+// collectTarget's per-target nrjmx subprocess model: every target must be
+// collected through its own client, concurrently, rather than queuing
+// behind a single shared connection the way runSingleHostCollection does.
+func TestRunTargetsCollectionFansOutInParallel(t *testing.T) {
+	dir := t.TempDir()
+	collectionPath := filepath.Join(dir, "collection.yml")
+	if err := ioutil.WriteFile(collectionPath, []byte(targetsTestCollectionYAML), 0644); err != nil {
+		t.Fatalf("failed to write collection file: %s", err)
+	}
+
+	targetsFilePath := filepath.Join(dir, "targets.yml")
+	targetsYAML := fmt.Sprintf(`
+targets:
+  - host: host-a
+    port: "9999"
+    collection_files: ["%s"]
+  - host: host-b
+    port: "9999"
+    collection_files: ["%s"]
+`, collectionPath, collectionPath)
+	if err := ioutil.WriteFile(targetsFilePath, []byte(targetsYAML), 0644); err != nil {
+		t.Fatalf("failed to write targets file: %s", err)
+	}
+
+	origArgs := args
+	defer func() { args = origArgs }()
+	args = argumentList{}
+	args.TargetsFile = targetsFilePath
+	args.Concurrency = 2
+
+	var active, maxActive int32
+	origOpen := openJMXSubprocessFunc
+	defer func() { openJMXSubprocessFunc = origOpen }()
+	openJMXSubprocessFunc = func(host, port, user, pass string) (*jmxSubprocessClient, error) {
+		n := atomic.AddInt32(&active, 1)
+		for {
+			cur := atomic.LoadInt32(&maxActive)
+			if n <= cur || atomic.CompareAndSwapInt32(&maxActive, cur, n) {
+				break
+			}
+		}
+		// Hold the subprocess "open" long enough that, if targets were
+		// collected serially instead of in parallel, only one would ever
+		// be active at a time and maxActive would never exceed 1.
+		time.Sleep(20 * time.Millisecond)
+		atomic.AddInt32(&active, -1)
+
+		return newCannedJMXSubprocessClient(`{"java.lang:type=Memory,HeapMemoryUsage":123}`), nil
+	}
+
+	jmxIntegration, err := integration.New(integrationName, integrationVersion)
+	if err != nil {
+		t.Fatalf("failed to build integration: %s", err)
+	}
+
+	if err := runTargetsCollection(jmxIntegration); err != nil {
+		t.Fatalf("runTargetsCollection returned error: %s", err)
+	}
+
+	if atomic.LoadInt32(&maxActive) < 2 {
+		t.Errorf("expected both targets to be collected concurrently, maxActive = %d", maxActive)
+	}
+
+	if len(jmxIntegration.Entities) != 2 {
+		t.Fatalf("expected 2 entities (one per target), got %d", len(jmxIntegration.Entities))
+	}
+
+	gotNames := map[string]bool{}
+	for _, entity := range jmxIntegration.Entities {
+		gotNames[entity.Metadata.Name] = true
+	}
+	wantNames := []string{"host-a:9999/JVMSample", "host-b:9999/JVMSample"}
+	for _, want := range wantNames {
+		if !gotNames[want] {
+			t.Errorf("expected an entity named %q, got %v", want, gotNames)
+		}
+	}
+}
+
+// TestRunTargetsCollectionRecordsFailureWithoutAbortingOthers ensures one
+// target failing to collect doesn't prevent the others from completing.
+func TestRunTargetsCollectionRecordsFailureWithoutAbortingOthers(t *testing.T) {
+	dir := t.TempDir()
+	collectionPath := filepath.Join(dir, "collection.yml")
+	if err := ioutil.WriteFile(collectionPath, []byte(targetsTestCollectionYAML), 0644); err != nil {
+		t.Fatalf("failed to write collection file: %s", err)
+	}
+
+	targetsFilePath := filepath.Join(dir, "targets.yml")
+	targetsYAML := fmt.Sprintf(`
+targets:
+  - host: good-host
+    port: "9999"
+    collection_files: ["%s"]
+  - host: bad-host
+    port: "9999"
+    collection_files: ["%s"]
+`, collectionPath, collectionPath)
+	if err := ioutil.WriteFile(targetsFilePath, []byte(targetsYAML), 0644); err != nil {
+		t.Fatalf("failed to write targets file: %s", err)
+	}
+
+	origArgs := args
+	defer func() { args = origArgs }()
+	args = argumentList{}
+	args.TargetsFile = targetsFilePath
+	args.Concurrency = 2
+
+	origOpen := openJMXSubprocessFunc
+	defer func() { openJMXSubprocessFunc = origOpen }()
+
+	var mu sync.Mutex
+	openJMXSubprocessFunc = func(host, port, user, pass string) (*jmxSubprocessClient, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if host == "bad-host" {
+			return nil, fmt.Errorf("connection refused")
+		}
+		return newCannedJMXSubprocessClient(`{"java.lang:type=Memory,HeapMemoryUsage":123}`), nil
+	}
+
+	jmxIntegration, err := integration.New(integrationName, integrationVersion)
+	if err != nil {
+		t.Fatalf("failed to build integration: %s", err)
+	}
+
+	if err := runTargetsCollection(jmxIntegration); err != nil {
+		t.Fatalf("runTargetsCollection returned error: %s", err)
+	}
+
+	var sawGoodEntity, sawErrorEntity bool
+	for _, entity := range jmxIntegration.Entities {
+		switch entity.Metadata.Name {
+		case "good-host:9999/JVMSample":
+			sawGoodEntity = true
+		case "bad-host:9999":
+			sawErrorEntity = true
+		}
+	}
+
+	if !sawGoodEntity {
+		t.Error("expected the healthy target to still be collected")
+	}
+	if !sawErrorEntity {
+		t.Error("expected the failing target to record an error entity")
+	}
+}